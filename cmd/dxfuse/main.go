@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dnanexus/dxda"
+	"github.com/dnanexus/dxfuse"
+	"github.com/dnanexus/dxfuse/exporter"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  dxfuse [options] <mountpoint> <manifest.json>
+  dxfuse digest <manifest.json>
+  dxfuse --dump-manifest <path.json> <manifest.json>
+  dxfuse export <manifest.json> <output-spec>
+      output-spec examples: type=tar,dest=-  type=local,dest=/some/dir
+
+options:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	verbose := flag.Bool("verbose", false, "Verbose mode")
+	debugFuse := flag.Bool("debugFuse", false, "Tap into the FUSE debug stream")
+	dumpManifest := flag.String("dump-manifest", "", "Write the current state of the mount's metadata DB back out as a manifest, to this path")
+	controlSocket := flag.String("control-socket", "", "Start the gRPC control plane on this unix socket alongside the mount")
+	readWrite := flag.Bool("readwrite", false, "Mount in write-back mode: local changes are staged under -staging-dir and journaled, then replayed against DNAnexus on unmount")
+	stagingDir := flag.String("staging-dir", "", "Staging directory for write-back mode (required with -readwrite)")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 2 && args[0] == "digest" {
+		runDigest(args[1])
+		return
+	}
+	if len(args) == 3 && args[0] == "export" {
+		runExport(args[1], args[2])
+		return
+	}
+	if *dumpManifest != "" {
+		if len(args) != 1 {
+			usage()
+			os.Exit(1)
+		}
+		runDumpManifest(*dumpManifest, args[0])
+		return
+	}
+	if len(args) != 2 {
+		usage()
+		os.Exit(1)
+	}
+	if *readWrite && *stagingDir == "" {
+		fmt.Fprintf(os.Stderr, "-staging-dir is required with -readwrite\n")
+		os.Exit(1)
+	}
+
+	mountpoint := args[0]
+	manifestFile := args[1]
+	dxEnv, _, err := dxda.GetDxEnvironment()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	manifest, err := dxfuse.ReadManifest(manifestFile, dxEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading manifest: %s\n", err)
+		os.Exit(1)
+	}
+
+	options := dxfuse.Options{
+		DebugFuse:     *debugFuse,
+		Verbose:       *verbose,
+		ControlSocket: *controlSocket,
+		ReadWrite:     *readWrite,
+		StagingDir:    *stagingDir,
+	}
+	if err := dxfuse.Mount(mountpoint, dxEnv, manifest, options); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Mount blocks until the filesystem is unmounted; once it returns,
+	// replay whatever write-back mode staged against DNAnexus. The
+	// project to upload into is the one the first mounted directory
+	// belongs to -- FlushJournal, like the rest of write-back mode, only
+	// supports a single-project mount.
+	if *readWrite && len(manifest.Directories) > 0 {
+		projId := manifest.Directories[0].ProjId
+		if _, err := dxfuse.FlushStagingDir(projId, *stagingDir); err != nil {
+			fmt.Fprintf(os.Stderr, "error flushing staged changes: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runDigest computes and prints the content digest of a manifest, without
+// mounting it. This is the digest dxfuse uses to name the cached sqlite
+// metadata DB for the manifest (see dxfuse.DatabaseFile), so it lets a
+// caller check ahead of time whether a mount will reuse an existing DB.
+func runDigest(manifestFile string) {
+	dxEnv, _, err := dxda.GetDxEnvironment()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	manifest, err := dxfuse.ReadManifest(manifestFile, dxEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading manifest: %s\n", err)
+		os.Exit(1)
+	}
+
+	digest, err := manifest.Digest()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error computing digest: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(digest)
+}
+
+// runDumpManifest re-serializes the metadata DB belonging to
+// manifestFile -- the one a running `dxfuse` mounted it with -- out to
+// outPath as a fresh manifest, reflecting whatever state the mount has
+// accumulated since it started (including any write-back changes staged
+// by writeback.go).
+func runDumpManifest(outPath, manifestFile string) {
+	dxEnv, _, err := dxda.GetDxEnvironment()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	manifest, err := dxfuse.ReadManifest(manifestFile, dxEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading manifest: %s\n", err)
+		os.Exit(1)
+	}
+	digest, err := manifest.Digest()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error computing digest: %s\n", err)
+		os.Exit(1)
+	}
+
+	fsys, err := dxfuse.OpenFilesysDB(dxfuse.DatabaseFile(digest))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	data, err := fsys.MarshalManifest(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error dumping manifest: %s\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(outPath, []byte(data), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %s\n", outPath, err)
+		os.Exit(1)
+	}
+}
+
+// runExport streams the files named in manifestFile straight from
+// DNAnexus according to outputSpec ("type=tar,dest=-" or
+// "type=local,dest=/some/dir"), without mounting anything. This is
+// meant for hosts where FUSE is unavailable, such as containers or CI.
+func runExport(manifestFile, outputSpec string) {
+	spec, err := exporter.ParseOutputSpec(outputSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	dxEnv, _, err := dxda.GetDxEnvironment()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	manifest, err := dxfuse.ReadManifest(manifestFile, dxEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading manifest: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := exporter.Export(manifest, dxEnv, spec); err != nil {
+		fmt.Fprintf(os.Stderr, "error exporting: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+// Package contenthash computes a stable digest over a dxfuse manifest's
+// directory tree. The digest is used as a cache key for the sqlite
+// metadata database: mounting the same manifest twice should reuse the
+// database built the first time, and mounting a slightly different
+// manifest should only invalidate the part of the tree that changed.
+package contenthash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// Leaf is a single entry to insert into the tree: Key is a cleaned,
+// absolute path, and Data is a deterministic encoding of the fields that
+// should participate in the digest at that path (e.g. a file's id, size,
+// ctime and mtime).
+type Leaf struct {
+	Key  string
+	Data string
+}
+
+// node is one entry in the radix tree: a leaf (data set), an internal
+// node with children, or both. Insert does not keep the two apart;
+// digest folds in a node's data and its children alike.
+type node struct {
+	data     string
+	children map[string]*node
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+// Tree is a radix tree keyed by cleaned, slash-separated paths, used to
+// compute a content digest for a manifest. Build it with Insert calls,
+// then call Digest once the tree is complete.
+type Tree struct {
+	root *node
+}
+
+// New returns an empty tree.
+func New() *Tree {
+	return &Tree{root: newNode()}
+}
+
+// Insert adds, or overwrites, the leaf at the given path.
+func (t *Tree) Insert(leaf Leaf) {
+	cur := t.root
+	for _, part := range splitPath(leaf.Key) {
+		child, ok := cur.children[part]
+		if !ok {
+			child = newNode()
+			cur.children[part] = child
+		}
+		cur = child
+	}
+	cur.data = leaf.Data
+}
+
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// Digest returns the root digest of the tree: a hex-encoded SHA-256,
+// computed bottom-up. A leaf hashes its own data; an internal node
+// hashes the concatenation of (childName, childDigest) pairs for its
+// children, visited in lexicographic order, on top of its own data, if
+// it has any. Because a subtree's digest only depends on that
+// subtree's contents, changing a single leaf only changes the digests
+// on the path from that leaf up to the root.
+func (t *Tree) Digest() string {
+	return digest(t.root)
+}
+
+func digest(n *node) string {
+	h := sha256.New()
+	h.Write([]byte(n.data))
+
+	if len(n.children) > 0 {
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			h.Write([]byte(name))
+			h.Write([]byte(digest(n.children[name])))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
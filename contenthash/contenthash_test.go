@@ -0,0 +1,82 @@
+package contenthash
+
+import "testing"
+
+func TestDigestDeterministic(t *testing.T) {
+	build := func() *Tree {
+		tree := New()
+		tree.Insert(Leaf{Key: "/a/b", Data: "b-data"})
+		tree.Insert(Leaf{Key: "/a/c", Data: "c-data"})
+		tree.Insert(Leaf{Key: "/d", Data: "d-data"})
+		return tree
+	}
+
+	if build().Digest() != build().Digest() {
+		t.Fatalf("digest of two separately-built, identical trees should match")
+	}
+}
+
+func TestDigestInsertOrderIndependent(t *testing.T) {
+	t1 := New()
+	t1.Insert(Leaf{Key: "/a/b", Data: "b-data"})
+	t1.Insert(Leaf{Key: "/a/c", Data: "c-data"})
+
+	t2 := New()
+	t2.Insert(Leaf{Key: "/a/c", Data: "c-data"})
+	t2.Insert(Leaf{Key: "/a/b", Data: "b-data"})
+
+	if t1.Digest() != t2.Digest() {
+		t.Fatalf("digest should not depend on the order leaves were inserted in")
+	}
+}
+
+func TestDigestChangesWithLeafData(t *testing.T) {
+	base := New()
+	base.Insert(Leaf{Key: "/a/b", Data: "b-data"})
+	base.Insert(Leaf{Key: "/a/c", Data: "c-data"})
+
+	changed := New()
+	changed.Insert(Leaf{Key: "/a/b", Data: "b-data-v2"})
+	changed.Insert(Leaf{Key: "/a/c", Data: "c-data"})
+
+	if base.Digest() == changed.Digest() {
+		t.Fatalf("changing a leaf's data should change the root digest")
+	}
+}
+
+func TestDigestUnaffectedByUnrelatedSubtree(t *testing.T) {
+	// Two trees sharing a /a/... subtree, differing only under /z, should
+	// disagree on the root digest but agree on the /a subtree's digest --
+	// changing a leaf under /z must not perturb the /a spine.
+	mkTree := func(zData string) *Tree {
+		tree := New()
+		tree.Insert(Leaf{Key: "/a/b", Data: "b-data"})
+		tree.Insert(Leaf{Key: "/a/c", Data: "c-data"})
+		tree.Insert(Leaf{Key: "/z", Data: zData})
+		return tree
+	}
+
+	t1 := mkTree("z-data")
+	t2 := mkTree("z-data-v2")
+
+	if t1.Digest() == t2.Digest() {
+		t.Fatalf("changing /z's data should change the root digest")
+	}
+
+	if digest(t1.root.children["a"]) != digest(t2.root.children["a"]) {
+		t.Fatalf("the /a subtree's digest should be unaffected by /z's contents")
+	}
+}
+
+func TestInsertOverwritesExistingLeaf(t *testing.T) {
+	t1 := New()
+	t1.Insert(Leaf{Key: "/a/b", Data: "first"})
+	t1.Insert(Leaf{Key: "/a/b", Data: "second"})
+
+	t2 := New()
+	t2.Insert(Leaf{Key: "/a/b", Data: "second"})
+
+	if t1.Digest() != t2.Digest() {
+		t.Fatalf("re-inserting the same key should overwrite, not accumulate, the leaf")
+	}
+}
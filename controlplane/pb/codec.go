@@ -0,0 +1,33 @@
+package pb
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Codec is a hand-rolled grpc.Codec for this package's message types.
+// They're plain Go structs with no protobuf field tags and no
+// Marshal/Unmarshal/ProtoReflect methods (see the package doc comment on
+// why they aren't generated), so grpc-go's default codec -- which
+// requires proto.Message -- can't serialize them. gob gives each of
+// these concrete struct types wire encoding without needing the
+// protoc/protoc-gen-go-grpc toolchain this checkout doesn't run. Install
+// it with grpc.CustomCodec(Codec{}) on both the server (see
+// controlplane.Serve) and any client dialing in.
+type Codec struct{}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (Codec) String() string {
+	return "dxfuse-gob"
+}
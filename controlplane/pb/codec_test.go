@@ -0,0 +1,49 @@
+package pb
+
+import "testing"
+
+func TestCodecRoundTripsAddFileRequest(t *testing.T) {
+	var c Codec
+
+	req := &AddFileRequest{
+		ProjId:        "project-xxxx",
+		FileId:        "file-001",
+		Parent:        "/a",
+		Fname:         "f.txt",
+		Size:          42,
+		CtimeMillisec: 1,
+		MtimeMillisec: 2,
+	}
+
+	data, err := c.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+
+	got := new(AddFileRequest)
+	if err := c.Unmarshal(data, got); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+
+	if *got != *req {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, req)
+	}
+}
+
+func TestCodecRoundTripsChangeEvent(t *testing.T) {
+	var c Codec
+
+	ev := &ChangeEvent{Path: "/a/b", Kind: "added"}
+	data, err := c.Marshal(ev)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+
+	got := new(ChangeEvent)
+	if err := c.Unmarshal(data, got); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if *got != *ev {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, ev)
+	}
+}
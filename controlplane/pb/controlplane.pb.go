@@ -0,0 +1,156 @@
+// Normally generated from controlplane.proto by
+// `protoc --go_out=. --go-grpc_out=. controlplane.proto`; checked in by
+// hand here because the build doesn't run protoc in this tree. Keep it
+// in sync with the .proto if either changes.
+package pb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type AddFileRequest struct {
+	ProjId        string
+	FileId        string
+	Parent        string
+	Fname         string
+	Size          int64
+	CtimeMillisec int64
+	MtimeMillisec int64
+}
+
+type AddFileResponse struct{}
+
+type AddDirectoryRequest struct {
+	ProjId        string
+	Folder        string
+	Dirname       string
+	CtimeMillisec int64
+	MtimeMillisec int64
+}
+
+type AddDirectoryResponse struct{}
+
+type RemoveEntryRequest struct {
+	Path string
+}
+
+type RemoveEntryResponse struct{}
+
+type ReloadManifestRequest struct {
+	ManifestPath string
+}
+
+type ReloadManifestResponse struct {
+	FilesAdded int32
+	DirsAdded  int32
+}
+
+type ListMountedRequest struct{}
+
+type ListMountedResponse struct {
+	Paths []string
+}
+
+type WatchRequest struct{}
+
+type ChangeEvent struct {
+	Path string
+	Kind string
+}
+
+// ControlPlane_WatchServer is the server-side stream handle for the
+// Watch RPC; ControlPlaneServer implementations call Send on it once per
+// ChangeEvent.
+type ControlPlane_WatchServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+// ControlPlaneServer is implemented by controlplane.Server.
+type ControlPlaneServer interface {
+	AddFile(context.Context, *AddFileRequest) (*AddFileResponse, error)
+	AddDirectory(context.Context, *AddDirectoryRequest) (*AddDirectoryResponse, error)
+	RemoveEntry(context.Context, *RemoveEntryRequest) (*RemoveEntryResponse, error)
+	ReloadManifest(context.Context, *ReloadManifestRequest) (*ReloadManifestResponse, error)
+	ListMounted(context.Context, *ListMountedRequest) (*ListMountedResponse, error)
+	Watch(*WatchRequest, ControlPlane_WatchServer) error
+}
+
+// RegisterControlPlaneServer registers srv's RPC handlers on s.
+func RegisterControlPlaneServer(s *grpc.Server, srv ControlPlaneServer) {
+	s.RegisterService(&_ControlPlane_serviceDesc, srv)
+}
+
+var _ControlPlane_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "controlplane.ControlPlane",
+	HandlerType: (*ControlPlaneServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "AddFile", Handler: addFileHandler},
+		{MethodName: "AddDirectory", Handler: addDirectoryHandler},
+		{MethodName: "RemoveEntry", Handler: removeEntryHandler},
+		{MethodName: "ReloadManifest", Handler: reloadManifestHandler},
+		{MethodName: "ListMounted", Handler: listMountedHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Watch", Handler: watchHandler, ServerStreams: true},
+	},
+	Metadata: "controlplane.proto",
+}
+
+func addFileHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(AddFileRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return srv.(ControlPlaneServer).AddFile(ctx, req)
+}
+
+func addDirectoryHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(AddDirectoryRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return srv.(ControlPlaneServer).AddDirectory(ctx, req)
+}
+
+func removeEntryHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(RemoveEntryRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return srv.(ControlPlaneServer).RemoveEntry(ctx, req)
+}
+
+func reloadManifestHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(ReloadManifestRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return srv.(ControlPlaneServer).ReloadManifest(ctx, req)
+}
+
+func listMountedHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(ListMountedRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return srv.(ControlPlaneServer).ListMounted(ctx, req)
+}
+
+func watchHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(WatchRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(ControlPlaneServer).Watch(req, &controlPlaneWatchServer{stream})
+}
+
+type controlPlaneWatchServer struct {
+	grpc.ServerStream
+}
+
+func (s *controlPlaneWatchServer) Send(ev *ChangeEvent) error {
+	return s.ServerStream.SendMsg(ev)
+}
@@ -0,0 +1,200 @@
+// Package controlplane exposes a gRPC service that mutates a live
+// dxfuse mount's namespace -- adding or removing manifest entries,
+// reloading a whole manifest, listing what's mounted, and streaming
+// change events -- without requiring an unmount/remount cycle.
+package controlplane
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"github.com/dnanexus/dxda"
+	"github.com/dnanexus/dxfuse"
+	"github.com/dnanexus/dxfuse/controlplane/pb"
+)
+
+// DefaultSocket is where Serve listens by default.
+const DefaultSocket = "/var/dxfuse/control.sock"
+
+// Server implements pb.ControlPlaneServer against a live Filesys.
+type Server struct {
+	fsys  *dxfuse.Filesys
+	dxEnv dxda.DXEnvironment
+
+	watchMu  sync.Mutex
+	watchers map[int]chan *pb.ChangeEvent
+	nextId   int
+}
+
+// New returns a Server backed by fsys.
+func New(fsys *dxfuse.Filesys, dxEnv dxda.DXEnvironment) *Server {
+	return &Server{
+		fsys:     fsys,
+		dxEnv:    dxEnv,
+		watchers: make(map[int]chan *pb.ChangeEvent),
+	}
+}
+
+// Serve listens on socketPath (a unix socket, DefaultSocket when
+// socketPath is empty; any stale socket file left behind by a previous
+// run is removed first) and serves RPCs against
+// fsys until the listener errors out. Mount must call this in a
+// goroutine, against the live fsys it constructs for the mount, whenever
+// Options.ControlSocket is set -- a separately-opened Filesys (e.g. the
+// read-only handle OpenFilesysDB hands back) can't be used here, since
+// every RPC but Watch mutates fsys.db.
+func Serve(socketPath string, fsys *dxfuse.Filesys, dxEnv dxda.DXEnvironment) error {
+	if socketPath == "" {
+		socketPath = DefaultSocket
+	}
+	os.Remove(socketPath)
+	lis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %s", socketPath, err)
+	}
+
+	// The messages in pb are hand-rolled, not protoc-generated, and
+	// don't implement proto.Message, so they need pb.Codec (gob-based)
+	// in place of grpc-go's default, proto-only codec.
+	grpcServer := grpc.NewServer(grpc.CustomCodec(pb.Codec{}))
+	pb.RegisterControlPlaneServer(grpcServer, New(fsys, dxEnv))
+	return grpcServer.Serve(lis)
+}
+
+func (s *Server) AddFile(ctx context.Context, req *pb.AddFileRequest) (*pb.AddFileResponse, error) {
+	delta := &dxfuse.Manifest{
+		Files: []dxfuse.ManifestFile{{
+			ProjId:        req.ProjId,
+			FileId:        req.FileId,
+			Parent:        req.Parent,
+			Fname:         req.Fname,
+			Size:          req.Size,
+			CtimeMillisec: req.CtimeMillisec,
+			MtimeMillisec: req.MtimeMillisec,
+		}},
+	}
+	if err := s.applyDelta(delta); err != nil {
+		return nil, err
+	}
+	s.notify(req.Parent+"/"+req.Fname, "added")
+	return &pb.AddFileResponse{}, nil
+}
+
+func (s *Server) AddDirectory(ctx context.Context, req *pb.AddDirectoryRequest) (*pb.AddDirectoryResponse, error) {
+	delta := &dxfuse.Manifest{
+		Directories: []dxfuse.ManifestDir{{
+			ProjId:        req.ProjId,
+			Folder:        req.Folder,
+			Dirname:       req.Dirname,
+			CtimeMillisec: req.CtimeMillisec,
+			MtimeMillisec: req.MtimeMillisec,
+		}},
+	}
+	if err := s.applyDelta(delta); err != nil {
+		return nil, err
+	}
+	s.notify(req.Dirname, "added")
+	return &pb.AddDirectoryResponse{}, nil
+}
+
+func (s *Server) RemoveEntry(ctx context.Context, req *pb.RemoveEntryRequest) (*pb.RemoveEntryResponse, error) {
+	if err := s.fsys.RemoveManifestEntry(req.Path); err != nil {
+		return nil, err
+	}
+	s.notify(req.Path, "removed")
+	return &pb.RemoveEntryResponse{}, nil
+}
+
+func (s *Server) ReloadManifest(ctx context.Context, req *pb.ReloadManifestRequest) (*pb.ReloadManifestResponse, error) {
+	manifest, err := dxfuse.ReadManifest(req.ManifestPath, s.dxEnv)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %s", req.ManifestPath, err)
+	}
+	skeleton, err := manifest.DirSkeleton()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fsys.InsertManifestDelta(manifest, skeleton); err != nil {
+		return nil, err
+	}
+
+	for _, fl := range manifest.Files {
+		s.notify(fl.Parent+"/"+fl.Fname, "added")
+	}
+	for _, d := range manifest.Directories {
+		s.notify(d.Dirname, "added")
+	}
+
+	return &pb.ReloadManifestResponse{
+		FilesAdded: int32(len(manifest.Files)),
+		DirsAdded:  int32(len(manifest.Directories)),
+	}, nil
+}
+
+func (s *Server) ListMounted(ctx context.Context, req *pb.ListMountedRequest) (*pb.ListMountedResponse, error) {
+	paths, err := s.fsys.ListPaths()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListMountedResponse{Paths: paths}, nil
+}
+
+// Watch streams a ChangeEvent for every mutation applied through this
+// service for as long as the caller keeps the RPC open. Mutations made
+// through write-back mode (writeback.go) are not reported.
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.ControlPlane_WatchServer) error {
+	ch := make(chan *pb.ChangeEvent, 16)
+
+	s.watchMu.Lock()
+	id := s.nextId
+	s.nextId++
+	s.watchers[id] = ch
+	s.watchMu.Unlock()
+
+	defer func() {
+		s.watchMu.Lock()
+		delete(s.watchers, id)
+		s.watchMu.Unlock()
+	}()
+
+	for {
+		select {
+		case ev := <-ch:
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *Server) notify(path, kind string) {
+	ev := &pb.ChangeEvent{Path: path, Kind: kind}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for _, ch := range s.watchers {
+		select {
+		case ch <- ev:
+		default:
+			// a slow watcher drops events rather than blocking mutations.
+		}
+	}
+}
+
+func (s *Server) applyDelta(delta *dxfuse.Manifest) error {
+	if err := delta.Validate(); err != nil {
+		return err
+	}
+	skeleton, err := delta.DirSkeleton()
+	if err != nil {
+		return err
+	}
+	return s.fsys.InsertManifestDelta(delta, skeleton)
+}
@@ -2,6 +2,7 @@ package dxfuse
 
 import (
 	"database/sql"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -11,12 +12,20 @@ import (
 )
 
 const (
-	DatabaseFile       = "/var/dxfuse/metadata.db"
+	DatabaseDir        = "/var/dxfuse"
 	HttpClientPoolSize = 4
 	LogFile            = "/var/log/dxfuse.log"
 	MaxDirSize         = 10 * 1000
 	Version            = "v0.1"
 )
+
+// DatabaseFile returns the path of the sqlite metadata DB for the
+// manifest whose content digest is given (see Manifest.Digest). Keying
+// the file name on the digest lets dxfuse reuse a DB built for an
+// unchanged manifest across invocations, instead of rebuilding it.
+func DatabaseFile(digest string) string {
+	return filepath.Join(DatabaseDir, digest+".db")
+}
 const (
 	InodeInvalid       = 0
 	InodeRoot          = 1
@@ -40,6 +49,17 @@ type Options struct {
 	VerboseLevel   int
 	Uid            int
 	Gid            int
+
+	// ReadWrite mounts the filesystem in write-back mode: local
+	// mutations are staged on disk and journaled, then replayed against
+	// DNAnexus on flush/unmount. See journal.go and writeback.go.
+	ReadWrite      bool
+	StagingDir     string
+
+	// ControlSocket, if non-empty, starts the gRPC control plane (see
+	// the controlplane package) on this unix socket alongside the mount,
+	// so an external process can mutate the namespace live.
+	ControlSocket  string
 }
 
 
@@ -68,12 +88,18 @@ type Filesys struct {
 	pgs PrefetchGlobalState
 
 	httpClientPool chan(*retryablehttp.Client)
+
+	// write-back state; nil unless the mount was opened with
+	// Options.ReadWrite. See journal.go and writeback.go.
+	journal    *Journal
+	stagingDir string
 }
 
 var _ fs.FS = (*Filesys)(nil)
 
 type Dir struct {
 	Fsys     *Filesys
+	ProjId    string  // the DNAx project (or container) this directory belongs to
 	Parent    string  // the parent directory, used for debugging
 	Dname     string  // This is the last part of the full path
 	FullPath  string // combine parent and dname, then normalize
@@ -92,6 +118,7 @@ type File struct {
 	Fsys     *Filesys
 	Id        string  // Required to build a download URL
 	ProjId    string  // Note: this could be a container
+	Parent    string  // the dxfuse path of the directory this file lives in; set for files created in write-back mode (see writeback.go)
 	Name      string
 	Size      int64
 	Inode     int64
@@ -0,0 +1,219 @@
+package dxfuse
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// InsertManifestDelta atomically adds the directories and files in
+// delta, plus any intermediate directories named in skeleton (see
+// Manifest.DirSkeleton), into the mount's metadata DB -- without
+// requiring an unmount/remount. It is the mutation primitive the gRPC
+// control plane (see the controlplane package) is built on.
+func (fsys *Filesys) InsertManifestDelta(delta *Manifest, skeleton []string) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	tx, err := fsys.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, dirPath := range skeleton {
+		fsys.inodeCnt++
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO directories (inode, proj_id, full_path) VALUES (?, ?, ?)`,
+			fsys.inodeCnt, "", dirPath); err != nil {
+			return fmt.Errorf("inserting skeleton directory %s: %s", dirPath, err)
+		}
+	}
+	for _, d := range delta.Directories {
+		fsys.inodeCnt++
+		if _, err := tx.Exec(
+			`INSERT INTO directories (inode, proj_id, full_path, ctime, mtime) VALUES (?, ?, ?, ?, ?)`,
+			fsys.inodeCnt, d.ProjId, d.Dirname, d.CtimeMillisec, d.MtimeMillisec); err != nil {
+			return fmt.Errorf("inserting directory %s: %s", d.Dirname, err)
+		}
+	}
+	for _, fl := range delta.Files {
+		fsys.inodeCnt++
+		path := fl.Parent + "/" + fl.Fname
+		if _, err := tx.Exec(
+			`INSERT INTO files (inode, proj_id, file_id, parent, name, size, ctime, mtime) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			fsys.inodeCnt, fl.ProjId, fl.FileId, fl.Parent, fl.Fname, fl.Size, fl.CtimeMillisec, fl.MtimeMillisec); err != nil {
+			return fmt.Errorf("inserting file %s: %s", path, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveManifestEntry deletes the file or directory at path from the
+// metadata DB. Removing a directory that still has children is an
+// error; callers should remove each child first.
+func (fsys *Filesys) RemoveManifestEntry(path string) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	res, err := fsys.db.Exec(`DELETE FROM files WHERE parent || '/' || name = ?`, path)
+	if err != nil {
+		return fmt.Errorf("removing file %s: %s", path, err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	var childCount int
+	row := fsys.db.QueryRow(`SELECT COUNT(*) FROM directories WHERE full_path LIKE ? || '/%'`, path)
+	if err := row.Scan(&childCount); err != nil {
+		return fmt.Errorf("checking children of %s: %s", path, err)
+	}
+	var fileCount int
+	row = fsys.db.QueryRow(`SELECT COUNT(*) FROM files WHERE parent = ? OR parent LIKE ? || '/%'`, path, path)
+	if err := row.Scan(&fileCount); err != nil {
+		return fmt.Errorf("checking children of %s: %s", path, err)
+	}
+	if childCount+fileCount > 0 {
+		return fmt.Errorf("cannot remove %s: directory is not empty", path)
+	}
+
+	res, err = fsys.db.Exec(`DELETE FROM directories WHERE full_path = ?`, path)
+	if err != nil {
+		return fmt.Errorf("removing directory %s: %s", path, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no such entry: %s", path)
+	}
+	return nil
+}
+
+// RenameManifestEntry moves the file or directory at src to dst within
+// the metadata DB, in place -- preserving the FileId/Size/etc a file
+// entry already carries, rather than deleting and re-inserting it. When
+// src is a (possibly non-empty) directory, every directory and file
+// nested under it is rewritten too, so a moved subtree doesn't leave its
+// children pointing at a parent path that no longer exists. It is used
+// both by the gRPC control plane and by write-back mode (see
+// writeback.go) to keep the live DB in sync with a rename.
+func (fsys *Filesys) RenameManifestEntry(src, dst string) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	tx, err := fsys.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	newParent := filepath.Dir(dst)
+	newName := filepath.Base(dst)
+	res, err := tx.Exec(
+		`UPDATE files SET parent = ?, name = ? WHERE parent || '/' || name = ?`,
+		newParent, newName, src)
+	if err != nil {
+		return fmt.Errorf("renaming file %s to %s: %s", src, dst, err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return tx.Commit()
+	}
+
+	res, err = tx.Exec(`UPDATE directories SET full_path = ? WHERE full_path = ?`, dst, src)
+	if err != nil {
+		return fmt.Errorf("renaming directory %s to %s: %s", src, dst, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no such entry: %s", src)
+	}
+
+	if err := cascadeRenamedPrefix(tx, "directories", "full_path", src, dst); err != nil {
+		return err
+	}
+	if err := cascadeRenamedPrefix(tx, "files", "parent", src, dst); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// cascadeRenamedPrefix rewrites every row in table whose pathCol is
+// nested under src (i.e. starts with src+"/") to be nested under dst
+// instead, preserving whatever comes after the src prefix. It's the
+// fix-up RenameManifestEntry applies to a directory's descendants once
+// the directory's own row has been renamed.
+func cascadeRenamedPrefix(tx *sql.Tx, table, pathCol, src, dst string) error {
+	query := fmt.Sprintf(`SELECT inode, %s FROM %s WHERE %s LIKE ? || '/%%'`, pathCol, table, pathCol)
+	rows, err := tx.Query(query, src)
+	if err != nil {
+		return fmt.Errorf("finding %s nested under %s: %s", table, src, err)
+	}
+
+	type rename struct {
+		inode   int64
+		newPath string
+	}
+	var renames []rename
+	for rows.Next() {
+		var inode int64
+		var p string
+		if err := rows.Scan(&inode, &p); err != nil {
+			rows.Close()
+			return err
+		}
+		renames = append(renames, rename{inode, dst + strings.TrimPrefix(p, src)})
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	update := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE inode = ?`, table, pathCol)
+	for _, r := range renames {
+		if _, err := tx.Exec(update, r.newPath, r.inode); err != nil {
+			return fmt.Errorf("rewriting %s path for inode %d: %s", table, r.inode, err)
+		}
+	}
+	return nil
+}
+
+// ListPaths returns the full path of every directory and file currently
+// in the metadata DB.
+func (fsys *Filesys) ListPaths() ([]string, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	var paths []string
+
+	dirRows, err := fsys.db.Query(`SELECT full_path FROM directories`)
+	if err != nil {
+		return nil, err
+	}
+	defer dirRows.Close()
+	for dirRows.Next() {
+		var p string
+		if err := dirRows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	if err := dirRows.Err(); err != nil {
+		return nil, err
+	}
+
+	fileRows, err := fsys.db.Query(`SELECT parent || '/' || name FROM files`)
+	if err != nil {
+		return nil, err
+	}
+	defer fileRows.Close()
+	for fileRows.Next() {
+		var p string
+		if err := fileRows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, fileRows.Err()
+}
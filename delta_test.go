@@ -0,0 +1,72 @@
+package dxfuse
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestFilesys(t *testing.T) *Filesys {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory db: %s", err)
+	}
+	schema := `
+CREATE TABLE directories (inode INTEGER PRIMARY KEY, proj_id TEXT, full_path TEXT, ctime INTEGER, mtime INTEGER);
+CREATE TABLE files (inode INTEGER PRIMARY KEY, proj_id TEXT, file_id TEXT, parent TEXT, name TEXT, size INTEGER, ctime INTEGER, mtime INTEGER);
+`
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("creating schema: %s", err)
+	}
+	return &Filesys{db: db}
+}
+
+func TestRenameManifestEntryCascadesNestedEntries(t *testing.T) {
+	fsys := newTestFilesys(t)
+
+	delta := &Manifest{
+		Directories: []ManifestDir{
+			{ProjId: "project-xxxx", Dirname: "/a"},
+			{ProjId: "project-xxxx", Dirname: "/a/b"},
+		},
+		Files: []ManifestFile{
+			{ProjId: "project-xxxx", FileId: "file-001", Parent: "/a/b", Fname: "f.txt"},
+		},
+	}
+	if err := fsys.InsertManifestDelta(delta, nil); err != nil {
+		t.Fatalf("seeding delta: %s", err)
+	}
+
+	if err := fsys.RenameManifestEntry("/a", "/z"); err != nil {
+		t.Fatalf("RenameManifestEntry: %s", err)
+	}
+
+	paths, err := fsys.ListPaths()
+	if err != nil {
+		t.Fatalf("ListPaths: %s", err)
+	}
+
+	got := make(map[string]bool)
+	for _, p := range paths {
+		got[p] = true
+		if strings.HasPrefix(p, "/a") {
+			t.Errorf("stale pre-rename path %s still present after rename, got paths %v", p, paths)
+		}
+	}
+	for _, want := range []string{"/z", "/z/b", "/z/b/f.txt"} {
+		if !got[want] {
+			t.Errorf("expected path %s to exist after rename, got paths %v", want, paths)
+		}
+	}
+}
+
+func TestRenameManifestEntryNoSuchPath(t *testing.T) {
+	fsys := newTestFilesys(t)
+
+	if err := fsys.RenameManifestEntry("/nope", "/also-nope"); err == nil {
+		t.Fatalf("expected an error renaming a path that doesn't exist, got nil")
+	}
+}
@@ -0,0 +1,181 @@
+// Package exporter streams the files named by a dxfuse manifest
+// directly from DNAnexus into a tar stream or a local directory tree,
+// without ever creating a FUSE mount. It is meant for batch/CI use on
+// hosts where FUSE is unavailable, such as containers or read-only
+// hosts.
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dnanexus/dxda"
+	"github.com/hashicorp/go-retryablehttp"
+
+	"github.com/dnanexus/dxfuse"
+)
+
+const (
+	chunkSize   = 64 * dxfuse.MiB
+	concurrency = 8
+)
+
+// OutputSpec describes where Export should send the resolved files:
+// Type is "tar" or "local", and Dest is either a file path, "-" for
+// stdout (tar only), or a directory (local only).
+type OutputSpec struct {
+	Type string
+	Dest string
+}
+
+// ParseOutputSpec parses a "key=value,key=value" spec like
+// "type=tar,dest=-" or "type=local,dest=/some/dir".
+func ParseOutputSpec(s string) (*OutputSpec, error) {
+	spec := &OutputSpec{}
+	for _, term := range strings.Split(s, ",") {
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed output spec term %q (want key=value)", term)
+		}
+		switch kv[0] {
+		case "type":
+			spec.Type = kv[1]
+		case "dest":
+			spec.Dest = kv[1]
+		default:
+			return nil, fmt.Errorf("unrecognized output spec key %q", kv[0])
+		}
+	}
+	if spec.Type != "tar" && spec.Type != "local" {
+		return nil, fmt.Errorf("unsupported output type %q (want tar or local)", spec.Type)
+	}
+	if spec.Dest == "" {
+		return nil, fmt.Errorf("output spec %q is missing dest=", s)
+	}
+	return spec, nil
+}
+
+// Export materializes manifest's files according to spec.
+func Export(manifest *dxfuse.Manifest, dxEnv dxda.DXEnvironment, spec *OutputSpec) error {
+	switch spec.Type {
+	case "tar":
+		return exportTar(manifest, dxEnv, spec.Dest)
+	case "local":
+		return exportLocal(manifest, dxEnv, spec.Dest)
+	default:
+		return fmt.Errorf("unsupported output type %q", spec.Type)
+	}
+}
+
+// downloadToFile fetches fl's bytes into dstPath, split into chunkSize
+// ranges fetched by a small pool of concurrent workers. This is
+// deliberately independent of the prefetcher (PrefetchGlobalState,
+// defs.go): that type is internal to a live, mounted Filesys and is
+// driven by FUSE read calls against pages it caches, neither of which
+// exists here -- the whole point of this package is to move files
+// without mounting anything. What's duplicated is only the chunking and
+// concurrency shape, not any retry/caching logic, which comes from
+// retryablehttp instead.
+func downloadToFile(httpClient *retryablehttp.Client, dxEnv *dxda.DXEnvironment, fl dxfuse.ManifestFile, dstPath string) error {
+	url, err := dxfuse.DxFileDownloadURL(httpClient, dxEnv, fl.FileId)
+	if err != nil {
+		return fmt.Errorf("getting download URL for %s: %s", fl.FileId, err)
+	}
+
+	fh, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if fl.Size > 0 {
+		if err := fh.Truncate(fl.Size); err != nil {
+			return err
+		}
+	}
+
+	type byteRange struct{ start, end int64 }
+	var ranges []byteRange
+	for start := int64(0); start < fl.Size; start += chunkSize {
+		end := dxfuse.MinInt64(start+chunkSize, fl.Size) - 1
+		ranges = append(ranges, byteRange{start, end})
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	rangeCh := make(chan byteRange)
+	errCh := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rangeCh {
+				if err := downloadRange(httpClient, url, fh, r.start, r.end); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+	for _, r := range ranges {
+		rangeCh <- r
+	}
+	close(rangeCh)
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return fmt.Errorf("downloading %s: %s", fl.FileId, err)
+		}
+	}
+	return nil
+}
+
+func downloadRange(httpClient *retryablehttp.Client, url dxfuse.DxDownloadURL, fh *os.File, start, end int64) error {
+	req, err := retryablehttp.NewRequest("GET", url.URL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range url.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s for range %d-%d", resp.Status, start, end)
+	}
+
+	_, err = io.Copy(&offsetWriter{fh: fh, offset: start}, resp.Body)
+	return err
+}
+
+// offsetWriter writes each Write call's bytes to fh starting at a fixed
+// file offset, advancing that offset as it goes -- used so concurrent
+// range downloads can land in the right place in a pre-sized file
+// without racing each other's file-position state.
+type offsetWriter struct {
+	fh     *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.fh.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+func millisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
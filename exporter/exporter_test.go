@@ -0,0 +1,41 @@
+package exporter
+
+import "testing"
+
+func TestParseOutputSpecTar(t *testing.T) {
+	spec, err := ParseOutputSpec("type=tar,dest=-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if spec.Type != "tar" || spec.Dest != "-" {
+		t.Fatalf("got %+v, want Type=tar Dest=-", spec)
+	}
+}
+
+func TestParseOutputSpecLocal(t *testing.T) {
+	spec, err := ParseOutputSpec("type=local,dest=/some/dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if spec.Type != "local" || spec.Dest != "/some/dir" {
+		t.Fatalf("got %+v, want Type=local Dest=/some/dir", spec)
+	}
+}
+
+func TestParseOutputSpecRejectsUnsupportedType(t *testing.T) {
+	if _, err := ParseOutputSpec("type=zip,dest=-"); err == nil {
+		t.Fatalf("expected an error for an unsupported output type, got nil")
+	}
+}
+
+func TestParseOutputSpecRejectsMissingDest(t *testing.T) {
+	if _, err := ParseOutputSpec("type=tar"); err == nil {
+		t.Fatalf("expected an error for a spec missing dest=, got nil")
+	}
+}
+
+func TestParseOutputSpecRejectsMalformedTerm(t *testing.T) {
+	if _, err := ParseOutputSpec("type=tar,dest"); err == nil {
+		t.Fatalf("expected an error for a malformed key=value term, got nil")
+	}
+}
@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dnanexus/dxda"
+
+	"github.com/dnanexus/dxfuse"
+)
+
+// exportLocal materializes manifest's files under destDir as a plain
+// directory tree. Files that share a FileId (the same DNAx file named
+// at more than one path in the manifest) are hardlinked to the first
+// copy materialized, or byte-copied from it where the hardlink fails
+// (see linkOrCopy), rather than downloaded again.
+func exportLocal(manifest *dxfuse.Manifest, dxEnv dxda.DXEnvironment, destDir string) error {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %s", destDir, err)
+	}
+
+	httpClient := dxda.NewHttpClient(false)
+	materialized := make(map[string]string) // FileId -> local path already downloaded
+
+	for _, fl := range manifest.Files {
+		localPath := filepath.Join(destDir, fl.Parent, fl.Fname)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+
+		if existing, ok := materialized[fl.FileId]; ok {
+			if err := linkOrCopy(existing, localPath); err != nil {
+				return fmt.Errorf("linking %s to %s: %s", localPath, existing, err)
+			}
+			continue
+		}
+
+		if err := downloadToFile(httpClient, &dxEnv, fl, localPath); err != nil {
+			return err
+		}
+		mtime := millisToTime(fl.MtimeMillisec)
+		if err := os.Chtimes(localPath, mtime, mtime); err != nil {
+			return err
+		}
+		materialized[fl.FileId] = localPath
+	}
+
+	return nil
+}
+
+// linkOrCopy hardlinks dst to src, falling back to a byte copy when the
+// two paths are on different filesystems (hardlinks can't cross
+// devices) or the filesystem doesn't support them.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	srcFh, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFh.Close()
+
+	dstFh, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer dstFh.Close()
+
+	_, err = io.Copy(dstFh, srcFh)
+	return err
+}
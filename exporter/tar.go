@@ -0,0 +1,84 @@
+package exporter
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dnanexus/dxda"
+
+	"github.com/dnanexus/dxfuse"
+)
+
+// exportTar streams manifest's files as a POSIX tar archive to dest,
+// where dest is either a file path or "-" for stdout. Each file's
+// bytes are downloaded to a scratch file first (so a failed download
+// aborts the export before that file's header or bytes reach the tar
+// stream), then copied into the archive with ctime/mtime/size taken from
+// the manifest.
+func exportTar(manifest *dxfuse.Manifest, dxEnv dxda.DXEnvironment, dest string) (err error) {
+	var out io.Writer
+	if dest == "-" {
+		out = os.Stdout
+	} else {
+		fh, err := os.Create(dest)
+		if err != nil {
+			return fmt.Errorf("creating %s: %s", dest, err)
+		}
+		defer fh.Close()
+		out = fh
+	}
+
+	tmpDir, err := ioutil.TempDir("", "dxfuse-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	httpClient := dxda.NewHttpClient(false)
+	tw := tar.NewWriter(out)
+	defer func() {
+		// Close writes the tar trailer; a failure here (full disk, a
+		// broken destination pipe) means the archive is truncated, so
+		// it must not be masked by a nil return from the loop below.
+		if closeErr := tw.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for _, fl := range manifest.Files {
+		tmpPath := filepath.Join(tmpDir, fl.FileId)
+		if err := downloadToFile(httpClient, &dxEnv, fl, tmpPath); err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(filepath.Join(fl.Parent, fl.Fname), "/")
+		hdr := &tar.Header{
+			Name:       name,
+			Size:       fl.Size,
+			Mode:       0644,
+			ModTime:    millisToTime(fl.MtimeMillisec),
+			ChangeTime: millisToTime(fl.CtimeMillisec),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("writing tar header for %s: %s", name, err)
+		}
+
+		fh, err := os.Open(tmpPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, fh)
+		fh.Close()
+		os.Remove(tmpPath)
+		if err != nil {
+			return fmt.Errorf("writing %s to tar: %s", name, err)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,182 @@
+package dxfuse
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// JournalFileName is the name, under a write-back mount's staging
+	// directory, of the append-only journal file OpenJournal/ReadJournal
+	// operate on.
+	JournalFileName = "journal"
+
+	// flushStateFileName is where FlushStagingDir persists the sequence
+	// number of the last journal entry it successfully applied, so a
+	// later flush (after a crash, or just a later `dxfuse -readwrite`
+	// invocation against the same staging directory) resumes instead of
+	// re-applying already-replayed entries.
+	flushStateFileName = "flushed.seq"
+)
+
+// JournalOp names the kind of mutation a JournalEntry records.
+type JournalOp string
+
+const (
+	OpCopy   JournalOp = "copy"   // stage a local file, destined to become/replace a DNAx file
+	OpMkdir  JournalOp = "mkdir"  // create a folder
+	OpRm     JournalOp = "rm"     // remove a file or folder
+	OpChmod  JournalOp = "chmod"  // change permissions (DNAx has no notion of this; recorded for local replay only)
+	OpMv     JournalOp = "mv"     // rename/move a file or folder
+)
+
+// JournalEntry is one append-only record of a local mutation made while
+// mounted in read-write mode. Dst is a dxfuse path, and so is Src for
+// OpMv; for OpCopy, Src is the local path of the staged object that
+// replay uploads. Digest, for OpCopy, is the sha256 of the staged file's
+// bytes. Replay resumes by sequence number (see FlushStagingDir); it
+// does not consult Digest.
+type JournalEntry struct {
+	Seq    int64     `json:"seq"`
+	Op     JournalOp `json:"op"`
+	Src    string    `json:"src,omitempty"`
+	Dst    string    `json:"dst"`
+	Mode   uint32    `json:"mode,omitempty"`
+	Digest string    `json:"digest,omitempty"`
+}
+
+// Journal is an append-only log of JournalEntry records, persisted to a
+// file in the staging directory. Flush/unmount replays the journal
+// against DNAnexus; a crash mid-replay can resume from the last
+// successfully-applied sequence number, because each op is committed to
+// disk before it is acted on.
+type Journal struct {
+	mutex sync.Mutex
+	fh    *os.File
+	seq   int64
+}
+
+// OpenJournal opens (creating if necessary) the journal file at path. If
+// the file already holds entries from a prior session, it resumes from
+// them: seq is initialized to the highest sequence number on record, so
+// that Append continues the same sequence rather than restarting at 1
+// and colliding with entries a previous session already wrote (and
+// possibly already replayed to DNAx).
+func OpenJournal(path string) (*Journal, error) {
+	entries, err := ReadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	var maxSeq int64
+	for _, entry := range entries {
+		if entry.Seq > maxSeq {
+			maxSeq = entry.Seq
+		}
+	}
+
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal %s: %s", path, err)
+	}
+	return &Journal{fh: fh, seq: maxSeq}, nil
+}
+
+// ReadJournal returns the entries currently recorded in the journal file
+// at path, in the order they were appended. It is used on startup to
+// recover the last sequence number and to find ops that were journaled
+// but never replayed to DNAx.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("corrupt journal record: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Append records a new entry with the next monotonic sequence number,
+// and fsyncs it before returning, so that the entry is durable before
+// the caller acts as though the operation happened.
+func (j *Journal) Append(op JournalOp, src, dst string, mode uint32, digest string) (JournalEntry, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.seq++
+	entry := JournalEntry{
+		Seq:    j.seq,
+		Op:     op,
+		Src:    src,
+		Dst:    dst,
+		Mode:   mode,
+		Digest: digest,
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	line = append(line, '\n')
+	if _, err := j.fh.Write(line); err != nil {
+		return JournalEntry{}, fmt.Errorf("appending to journal: %s", err)
+	}
+	if err := j.fh.Sync(); err != nil {
+		return JournalEntry{}, fmt.Errorf("syncing journal: %s", err)
+	}
+	return entry, nil
+}
+
+// Close closes the underlying journal file.
+func (j *Journal) Close() error {
+	return j.fh.Close()
+}
+
+// readFlushState returns the sequence number of the last journal entry
+// FlushStagingDir successfully applied against DNAnexus for the mount
+// staged under stagingDir, or 0 if nothing has been flushed yet.
+func readFlushState(stagingDir string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(stagingDir, flushStateFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	seq, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("corrupt flush state: %s", err)
+	}
+	return seq, nil
+}
+
+// writeFlushState durably records seq as the last journal entry applied
+// for the mount staged under stagingDir.
+func writeFlushState(stagingDir string, seq int64) error {
+	path := filepath.Join(stagingDir, flushStateFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(seq, 10)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
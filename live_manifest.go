@@ -0,0 +1,130 @@
+package dxfuse
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// MarshalManifest walks the mount's sqlite metadata DB -- the durable
+// record of every directory and file discovered so far -- and emits a
+// JSON manifest equivalent to the one ReadManifest would parse back in,
+// optionally re-rooted under prefix (pass "." to keep paths as-is). This
+// lets a mount built up interactively, or through the write-back mode in
+// writeback.go, be serialized back into a manifest file that can be
+// handed to another dxfuse invocation or shared with collaborators.
+func (fsys *Filesys) MarshalManifest(prefix string) (string, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	dirs, err := fsys.queryDirectories()
+	if err != nil {
+		return "", err
+	}
+	files, err := fsys.queryFiles()
+	if err != nil {
+		return "", err
+	}
+
+	prefix = filepath.Clean(prefix)
+	for i := range dirs {
+		dirs[i].Dirname = rerootPath(dirs[i].Dirname, prefix)
+	}
+	for i := range files {
+		files[i].Parent = rerootPath(files[i].Parent, prefix)
+	}
+
+	m := &Manifest{
+		Files:       files,
+		Directories: dirs,
+	}
+	if err := m.Validate(); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// rerootPath re-parents p under prefix; "." (the default) leaves p
+// unchanged.
+func rerootPath(p, prefix string) string {
+	if prefix == "." {
+		return p
+	}
+	return filepath.Clean(filepath.Join(prefix, p))
+}
+
+// Size returns the total number of bytes across every file this mount
+// has discovered so far, whether or not the file's contents have
+// actually been read yet. Monitoring tools use this to report aggregate
+// mounted-byte counts without enumerating inodes themselves.
+func (fsys *Filesys) Size() int64 {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	row := fsys.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM files`)
+	var total int64
+	if err := row.Scan(&total); err != nil {
+		return 0
+	}
+	return total
+}
+
+func (fsys *Filesys) queryDirectories() ([]ManifestDir, error) {
+	rows, err := fsys.db.Query(`SELECT proj_id, full_path, ctime, mtime FROM directories`)
+	if err != nil {
+		return nil, fmt.Errorf("querying directories: %s", err)
+	}
+	defer rows.Close()
+
+	var dirs []ManifestDir
+	for rows.Next() {
+		var d ManifestDir
+		if err := rows.Scan(&d.ProjId, &d.Dirname, &d.CtimeMillisec, &d.MtimeMillisec); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, rows.Err()
+}
+
+func (fsys *Filesys) queryFiles() ([]ManifestFile, error) {
+	rows, err := fsys.db.Query(`SELECT proj_id, file_id, parent, name, size, ctime, mtime FROM files`)
+	if err != nil {
+		return nil, fmt.Errorf("querying files: %s", err)
+	}
+	defer rows.Close()
+
+	var files []ManifestFile
+	for rows.Next() {
+		var f ManifestFile
+		if err := rows.Scan(&f.ProjId, &f.FileId, &f.Parent, &f.Fname, &f.Size, &f.CtimeMillisec, &f.MtimeMillisec); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// OpenFilesysDB opens the sqlite metadata DB at dbPath read-only and
+// wraps it in just enough of a Filesys to call MarshalManifest and Size
+// against it. It is used by the `dxfuse --dump-manifest` CLI path to
+// inspect a mount's metadata DB directly, without needing a live FUSE
+// server to be running.
+func OpenFilesysDB(dbPath string) (*Filesys, error) {
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("opening metadata DB %s: %s", dbPath, err)
+	}
+	return &Filesys{
+		dbFullPath: dbPath,
+		db:         db,
+	}, nil
+}
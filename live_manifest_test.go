@@ -0,0 +1,30 @@
+package dxfuse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarshalManifestAcceptsNonFileObjectIds(t *testing.T) {
+	fsys := newTestFilesys(t)
+
+	delta := &Manifest{
+		Directories: []ManifestDir{
+			{ProjId: "project-xxxx", Dirname: "/a"},
+		},
+		Files: []ManifestFile{
+			{ProjId: "project-xxxx", FileId: "applet-001", Parent: "/a", Fname: "my_applet"},
+		},
+	}
+	if err := fsys.InsertManifestDelta(delta, nil); err != nil {
+		t.Fatalf("seeding delta: %s", err)
+	}
+
+	data, err := fsys.MarshalManifest(".")
+	if err != nil {
+		t.Fatalf("MarshalManifest rejected a manifest with an applet- file id: %s", err)
+	}
+	if !strings.Contains(data, "applet-001") {
+		t.Fatalf("expected marshaled manifest to contain applet-001, got: %s", data)
+	}
+}
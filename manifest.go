@@ -12,13 +12,21 @@ import (
 	"strings"
 
 	"github.com/dnanexus/dxda"
+	"github.com/dnanexus/dxfuse/contenthash"
 )
 
 type ManifestFile struct {
 	ProjId  string        `json:"proj_id"`
-	FileId  string        `json:"file_id"`
+	FileId  string        `json:"file_id,omitempty"`
 	Parent  string        `json:"parent"`
 
+	// Instead of a literal file-id, the user may specify a folder plus a
+	// glob pattern (e.g. "/assays/*.bam"). During load, this is expanded
+	// into one or more concrete ManifestFile entries with FileId filled in.
+	Folder    string      `json:"folder,omitempty"`
+	FileGlob  string      `json:"file_glob,omitempty"`
+	Recursive bool        `json:"recursive,omitempty"`
+
 	// These may not be provided by the user. Then, we
 	// need to query DNAx for the information.
 	Fname   string       `json:"fname,omitempty"`
@@ -66,12 +74,32 @@ func validProject(pId string) bool {
 	return false
 }
 
+// validDataObjectIdPrefixes are the DNAx object kinds a ManifestFile may
+// name (see File.Id in defs.go: "it could be a workflow or an applet",
+// in addition to the common case of a plain data file).
+var validDataObjectIdPrefixes = []string{"file-", "applet-", "workflow-", "record-", "database-"}
+
+func validDataObjectId(id string) bool {
+	for _, prefix := range validDataObjectIdPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Manifest)Validate() error {
 	for _, fl := range m.Files {
 		if !validProject(fl.ProjId) {
 			return fmt.Errorf("project has invalid ID %s", fl.ProjId)
 		}
-		if !strings.HasPrefix(fl.FileId, "file-") {
+		if fl.FileGlob != "" {
+			// this entry has not been expanded yet; it carries a pattern
+			// instead of a concrete file-id.
+			if err := validateDirName(fl.Folder); err != nil {
+				return err
+			}
+		} else if !validDataObjectId(fl.FileId) {
 			return fmt.Errorf("file has invalid ID %s", fl.FileId)
 		}
 		if err := validateDirName(fl.Parent); err != nil {
@@ -103,7 +131,7 @@ func (m *Manifest) Clean() {
 }
 
 // read the manifest from a file into a memory structure
-func ReadManifest(fname string) (*Manifest, error) {
+func ReadManifest(fname string, dxEnv dxda.DXEnvironment) (*Manifest, error) {
 	srcData, err := ioutil.ReadFile(fname)
 	if err != nil {
 		panic(err)
@@ -119,6 +147,9 @@ func ReadManifest(fname string) (*Manifest, error) {
 		return nil, err
 	}
 	m := &mRaw
+	if err := m.expandGlobs(dxEnv); err != nil {
+		return nil, err
+	}
 	if err := m.Validate(); err != nil {
 		return nil, err
 	}
@@ -126,6 +157,95 @@ func ReadManifest(fname string) (*Manifest, error) {
 	return m, nil
 }
 
+// DxDataObjectDesc is the subset of a DNAx data object's description
+// that DxFindDataObjects returns for each match of a glob.
+type DxDataObjectDesc struct {
+	Id            string
+	Name          string
+	Size          int64
+	CtimeMillisec int64
+	MtimeMillisec int64
+}
+
+// expandGlobs resolves any ManifestFile entries that carry a FileGlob
+// pattern into one or more concrete entries (ProjId/FileId/Parent/Fname
+// filled in), by asking DNAx for the files matching the pattern under
+// ProjId+Folder. Entries that already name a concrete FileId pass through
+// unchanged.
+func (m *Manifest) expandGlobs(dxEnv dxda.DXEnvironment) error {
+	needsExpansion := false
+	for _, fl := range m.Files {
+		if fl.FileGlob != "" {
+			needsExpansion = true
+			break
+		}
+	}
+	if !needsExpansion {
+		return nil
+	}
+
+	tmpHttpClient := dxda.NewHttpClient(false)
+	var expanded []ManifestFile
+	for _, fl := range m.Files {
+		if fl.FileGlob == "" {
+			expanded = append(expanded, fl)
+			continue
+		}
+
+		matches, err := DxFindDataObjects(tmpHttpClient, &dxEnv, fl.ProjId, fl.Folder, fl.FileGlob, fl.Recursive)
+		if err != nil {
+			return fmt.Errorf("error expanding glob %s in %s:%s: %s",
+				fl.FileGlob, fl.ProjId, fl.Folder, err)
+		}
+
+		globExpanded, err := expandGlobMatches(fl, matches)
+		if err != nil {
+			return err
+		}
+		expanded = append(expanded, globExpanded...)
+	}
+
+	m.Files = expanded
+	return nil
+}
+
+// expandGlobMatches turns the DNAx matches for fl's glob into concrete
+// ManifestFile entries: duplicates (the same file-id matched more than
+// once, which DNAx's find API can do for recursive folder searches) are
+// dropped, and the result is sorted by file-id so that the resulting
+// skeleton is deterministic regardless of the order DNAx returned
+// matches in. A glob that matches nothing is an error, consistent with
+// every other validation path in this file failing loudly on bad input
+// rather than silently shrinking the manifest.
+func expandGlobMatches(fl ManifestFile, matches []DxDataObjectDesc) ([]ManifestFile, error) {
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("glob %s in %s:%s matched no files",
+			fl.FileGlob, fl.ProjId, fl.Folder)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Id < matches[j].Id
+	})
+
+	var expanded []ManifestFile
+	seen := make(map[string]bool)
+	for _, desc := range matches {
+		if seen[desc.Id] {
+			continue
+		}
+		seen[desc.Id] = true
+		expanded = append(expanded, ManifestFile{
+			ProjId:        fl.ProjId,
+			FileId:        desc.Id,
+			Parent:        fl.Parent,
+			Fname:         desc.Name,
+			Size:          desc.Size,
+			CtimeMillisec: desc.CtimeMillisec,
+			MtimeMillisec: desc.MtimeMillisec,
+		})
+	}
+	return expanded, nil
+}
 
 func MakeManifestFromProjectIds(
 	dxEnv dxda.DXEnvironment,
@@ -285,6 +405,47 @@ It is a node in the middle, which is illegal.
 	return retval, nil
 }
 
+// dirHeaderKey is the reserved child name a directory's own header record
+// is inserted under, so that the directory's path itself never carries a
+// leaf -- it stays a pure internal node whose digest folds the header
+// together with every child, file or directory, beneath it. No real file
+// or directory name can collide with it, since it's not a legal DNAx
+// name.
+const dirHeaderKey = "\x00header"
+
+// Digest computes a stable content hash over the manifest's directory
+// tree: every directory gets a "header" leaf, recording its own
+// ProjId/Ctime/Mtime, inserted at dirHeaderKey under the directory's
+// path, and every file gets a leaf keyed on its full path (Parent+Fname,
+// holding FileId/Size/Ctime/Mtime). This keeps each directory's own node
+// purely internal -- it is never itself a leaf, only a parent of the
+// header and of everything nested under it -- while still letting the directory's node digest act as the
+// "recursive contents" record for that subtree (the header is one of
+// the inputs folded into it). The returned digest names the sqlite
+// metadata DB for this manifest (see DatabaseFile), so an unchanged
+// manifest reuses an existing DB, and a single changed leaf, via
+// FillInMissingFields, only invalidates that leaf's logarithmic spine to
+// the root rather than the whole tree.
+func (m *Manifest) Digest() (string, error) {
+	tree := contenthash.New()
+
+	for _, d := range m.Directories {
+		tree.Insert(contenthash.Leaf{
+			Key:  filepath.Join(d.Dirname, dirHeaderKey),
+			Data: fmt.Sprintf("dir:%s:%d:%d", d.ProjId, d.CtimeMillisec, d.MtimeMillisec),
+		})
+	}
+
+	for _, fl := range m.Files {
+		tree.Insert(contenthash.Leaf{
+			Key:  filepath.Join(fl.Parent, fl.Fname),
+			Data: fmt.Sprintf("file:%s:%d:%d:%d", fl.FileId, fl.Size, fl.CtimeMillisec, fl.MtimeMillisec),
+		})
+	}
+
+	return tree.Digest(), nil
+}
+
 func (m *Manifest) FillInMissingFields(dxEnv dxda.DXEnvironment) error {
 	tmpHttpClient := dxda.NewHttpClient(false)
 
@@ -0,0 +1,57 @@
+package dxfuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandGlobMatchesDedupsAndSorts(t *testing.T) {
+	fl := ManifestFile{ProjId: "project-xxxx", Parent: "/a", FileGlob: "*.bam", Folder: "/reads"}
+	matches := []DxDataObjectDesc{
+		{Id: "file-002", Name: "b.bam", Size: 20, CtimeMillisec: 2, MtimeMillisec: 2},
+		{Id: "file-001", Name: "a.bam", Size: 10, CtimeMillisec: 1, MtimeMillisec: 1},
+		// a duplicate of file-001, as DNAx's find API can return for
+		// recursive folder searches with overlapping results.
+		{Id: "file-001", Name: "a.bam", Size: 10, CtimeMillisec: 1, MtimeMillisec: 1},
+	}
+
+	got, err := expandGlobMatches(fl, matches)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []ManifestFile{
+		{ProjId: "project-xxxx", FileId: "file-001", Parent: "/a", Fname: "a.bam", Size: 10, CtimeMillisec: 1, MtimeMillisec: 1},
+		{ProjId: "project-xxxx", FileId: "file-002", Parent: "/a", Fname: "b.bam", Size: 20, CtimeMillisec: 2, MtimeMillisec: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expandGlobMatches() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExpandGlobMatchesOrderIndependent(t *testing.T) {
+	fl := ManifestFile{ProjId: "project-xxxx", Parent: "/a", FileGlob: "*.bam", Folder: "/reads"}
+	a := DxDataObjectDesc{Id: "file-001", Name: "a.bam"}
+	b := DxDataObjectDesc{Id: "file-002", Name: "b.bam"}
+
+	got1, err := expandGlobMatches(fl, []DxDataObjectDesc{a, b})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got2, err := expandGlobMatches(fl, []DxDataObjectDesc{b, a})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got1, got2) {
+		t.Fatalf("expandGlobMatches() should not depend on DNAx's match order: %+v vs %+v", got1, got2)
+	}
+}
+
+func TestExpandGlobMatchesNoMatchesIsError(t *testing.T) {
+	fl := ManifestFile{ProjId: "project-xxxx", Parent: "/a", FileGlob: "*.bam", Folder: "/reads"}
+
+	_, err := expandGlobMatches(fl, nil)
+	if err == nil {
+		t.Fatalf("expected an error for a glob that matches no files, got nil")
+	}
+}
@@ -0,0 +1,376 @@
+package dxfuse
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// This file implements write-back mode: Dir and File grow the bazil.org/fuse
+// write/create/mkdir/unlink/rename interfaces, backed by a local staging
+// directory plus the append-only Journal (see journal.go). Nothing is sent
+// to DNAnexus synchronously; FlushJournal (called on unmount, or on demand)
+// replays the journal and does the actual uploads/renames/removals.
+
+var _ fs.NodeCreater = (*Dir)(nil)
+var _ fs.NodeMkdirer = (*Dir)(nil)
+var _ fs.NodeRemover = (*Dir)(nil)
+var _ fs.NodeRenamer = (*Dir)(nil)
+var _ fs.NodeSetattrer = (*File)(nil)
+
+// InitWriteBack opens (or resumes) the journal for a write-back mount
+// and records the staging directory on fsys, turning on the write path
+// in Create/Mkdir/Remove/Rename/Setattr below (they all gate on
+// fsys.journal being non-nil; Release is only reached through a handle
+// Create returned). Mount must call this once, right after
+// constructing fsys and before serving any FUSE requests, whenever
+// fsys.options.ReadWrite is set; without it, fsys.journal stays nil and
+// every write-back entry point unconditionally returns fuse.EPERM.
+func (fsys *Filesys) InitWriteBack() error {
+	if !fsys.options.ReadWrite {
+		return nil
+	}
+	if fsys.options.StagingDir == "" {
+		return fmt.Errorf("write-back mode requires Options.StagingDir")
+	}
+
+	journal, err := OpenJournal(filepath.Join(fsys.options.StagingDir, JournalFileName))
+	if err != nil {
+		return err
+	}
+	fsys.journal = journal
+	fsys.stagingDir = fsys.options.StagingDir
+	return nil
+}
+
+// WriteHandle is the fuse Handle returned for a newly created file.
+// Writes land in a private temp file under the staging directory; the
+// bytes are only content-addressed and journaled once the handle is
+// released, so each released file yields one OpCopy entry for its final
+// contents rather than one per write.
+type WriteHandle struct {
+	f       *File
+	dirPath string // the dxfuse path of the directory the file was created in
+	tmpFile *os.File
+	tmpPath string
+}
+
+var _ fs.HandleWriter = (*WriteHandle)(nil)
+var _ fs.HandleReleaser = (*WriteHandle)(nil)
+
+// Create makes a new, empty file in dir, staged locally, and returns a
+// WriteHandle ready to receive the file's bytes.
+func (dir *Dir) Create(
+	ctx context.Context,
+	req *fuse.CreateRequest,
+	resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	fsys := dir.Fsys
+	if fsys.journal == nil {
+		return nil, nil, fuse.EPERM
+	}
+
+	tmpFile, tmpPath, err := createStagingTemp(fsys.stagingDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fsys.mutex.Lock()
+	fsys.inodeCnt++
+	inode := fsys.inodeCnt
+	fsys.mutex.Unlock()
+
+	file := &File{
+		Fsys:   fsys,
+		ProjId: dir.ProjId,
+		Parent: dir.FullPath,
+		Name:   req.Name,
+		Inode:  inode,
+		Nlink:  1,
+	}
+	return file, &WriteHandle{f: file, dirPath: dir.FullPath, tmpFile: tmpFile, tmpPath: tmpPath}, nil
+}
+
+// Mkdir journals creation of a new directory (DNAnexus folders are
+// created lazily when the journal is replayed) and inserts it into the
+// live metadata DB immediately, so it shows up in ls, MarshalManifest,
+// etc. without waiting for a replay.
+func (dir *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	fsys := dir.Fsys
+	if fsys.journal == nil {
+		return nil, fuse.EPERM
+	}
+
+	childPath := filepath.Join(dir.FullPath, req.Name)
+	if _, err := fsys.journal.Append(OpMkdir, "", childPath, uint32(req.Mode), ""); err != nil {
+		return nil, err
+	}
+
+	now := nowMillis()
+	delta := &Manifest{
+		Directories: []ManifestDir{{
+			ProjId:        dir.ProjId,
+			Dirname:       childPath,
+			CtimeMillisec: now,
+			MtimeMillisec: now,
+		}},
+	}
+	if err := fsys.InsertManifestDelta(delta, nil); err != nil {
+		return nil, err
+	}
+
+	return &Dir{
+		Fsys:     fsys,
+		ProjId:   dir.ProjId,
+		Parent:   dir.FullPath,
+		Dname:    req.Name,
+		FullPath: childPath,
+	}, nil
+}
+
+// Remove journals removal of a file or (empty) subdirectory, and
+// removes it from the live metadata DB so it stops showing up
+// immediately, ahead of the eventual replay against DNAnexus.
+func (dir *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	fsys := dir.Fsys
+	if fsys.journal == nil {
+		return fuse.EPERM
+	}
+
+	childPath := filepath.Join(dir.FullPath, req.Name)
+	if _, err := fsys.journal.Append(OpRm, "", childPath, 0, ""); err != nil {
+		return err
+	}
+	return fsys.RemoveManifestEntry(childPath)
+}
+
+// Rename journals a move of a file or directory, possibly across
+// directories, and applies the same move to the live metadata DB.
+func (dir *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	fsys := dir.Fsys
+	if fsys.journal == nil {
+		return fuse.EPERM
+	}
+
+	dstDir, ok := newDir.(*Dir)
+	if !ok {
+		return fmt.Errorf("rename target is not a directory node")
+	}
+
+	src := filepath.Join(dir.FullPath, req.OldName)
+	dst := filepath.Join(dstDir.FullPath, req.NewName)
+	if _, err := fsys.journal.Append(OpMv, src, dst, 0, ""); err != nil {
+		return err
+	}
+	return fsys.RenameManifestEntry(src, dst)
+}
+
+// Setattr handles chmod in write-back mode by journaling an OpChmod
+// entry with the new mode; FlushJournal treats OpChmod as local-only
+// bookkeeping, since DNAx has no POSIX permission bits to push it to.
+// Other attribute changes (size, times, ...) aren't meaningful for a
+// file whose bytes are either still being written or already staged, so
+// they're accepted without being journaled.
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	fsys := f.Fsys
+	if fsys.journal == nil {
+		return fuse.EPERM
+	}
+	if !req.Valid.Mode() {
+		return nil
+	}
+
+	path := filepath.Join(f.Parent, f.Name)
+	_, err := fsys.journal.Append(OpChmod, "", path, uint32(req.Mode), "")
+	return err
+}
+
+// Write appends bytes to the handle's staging temp file at the given
+// offset.
+func (wh *WriteHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	n, err := wh.tmpFile.WriteAt(req.Data, req.Offset)
+	if err != nil {
+		return err
+	}
+	resp.Size = n
+	return nil
+}
+
+// Release finalizes the staged file: its bytes are moved into the
+// content-addressed object store (deduplicating against any identical
+// file already staged), an OpCopy entry pointing at the object's digest
+// is appended to the journal, and the file is inserted into the live
+// metadata DB so it shows up in ls, MarshalManifest, Size, etc. right
+// away, ahead of the eventual upload.
+func (wh *WriteHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	defer wh.tmpFile.Close()
+
+	digest, err := fileDigest(wh.tmpFile)
+	if err != nil {
+		os.Remove(wh.tmpPath)
+		return err
+	}
+
+	fsys := wh.f.Fsys
+	objPath := stagingObjectPath(fsys.stagingDir, digest)
+	size, err := stagedObjectSize(wh.tmpPath, objPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(objPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(objPath), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(wh.tmpPath, objPath); err != nil {
+			return err
+		}
+	} else {
+		// identical content already staged; drop the duplicate.
+		os.Remove(wh.tmpPath)
+	}
+
+	dstPath := filepath.Join(wh.dirPath, wh.f.Name)
+	if _, err := fsys.journal.Append(OpCopy, objPath, dstPath, 0, digest); err != nil {
+		return err
+	}
+
+	now := nowMillis()
+	delta := &Manifest{
+		Files: []ManifestFile{{
+			ProjId:        wh.f.ProjId,
+			Parent:        wh.dirPath,
+			Fname:         wh.f.Name,
+			Size:          size,
+			CtimeMillisec: now,
+			MtimeMillisec: now,
+		}},
+	}
+	return fsys.InsertManifestDelta(delta, nil)
+}
+
+// stagedObjectSize returns the size of the staged file, whether it still
+// lives at tmpPath or has already been deduplicated against an
+// identically-content-addressed object at objPath.
+func stagedObjectSize(tmpPath, objPath string) (int64, error) {
+	info, err := os.Stat(tmpPath)
+	if os.IsNotExist(err) {
+		info, err = os.Stat(objPath)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func createStagingTemp(stagingDir string) (*os.File, string, error) {
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return nil, "", err
+	}
+	tmpFile, err := ioutil.TempFile(stagingDir, "stage-")
+	if err != nil {
+		return nil, "", err
+	}
+	return tmpFile, tmpFile.Name(), nil
+}
+
+// stagingObjectPath returns the content-addressed location, under the
+// staging directory, of the object with the given sha256 digest.
+func stagingObjectPath(stagingDir, digest string) string {
+	return filepath.Join(stagingDir, "objects", digest[:2], digest[2:])
+}
+
+func fileDigest(fh *os.File) (string, error) {
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FlushJournal replays every entry in entries with Seq > resumeFrom
+// against DNAnexus: OpCopy entries are pushed with `dx upload`, OpMv
+// with `dx mv`, OpRm with `dx rm`, OpMkdir with `dx mkdir`, and OpChmod
+// is a local-only bookkeeping record (DNAx has no POSIX permission bits)
+// that is skipped. It returns the sequence number of the last entry it
+// successfully applied, so that a caller interrupted partway through --
+// by a crash or an unmount under load -- can resume from there rather
+// than redoing work (or, for uploads, re-uploading bytes already
+// pushed); FlushStagingDir is that caller for the common case of
+// flushing a whole staging directory's journal.
+func FlushJournal(projId string, entries []JournalEntry, resumeFrom int64) (int64, error) {
+	applied := resumeFrom
+	for _, entry := range entries {
+		if entry.Seq <= resumeFrom {
+			continue
+		}
+
+		var cmd *exec.Cmd
+		switch entry.Op {
+		case OpCopy:
+			cmd = exec.Command("dx", "upload", entry.Src, "--destination", projId+":"+entry.Dst, "--brief")
+		case OpMkdir:
+			cmd = exec.Command("dx", "mkdir", "-p", projId+":"+entry.Dst)
+		case OpRm:
+			cmd = exec.Command("dx", "rm", "-r", projId+":"+entry.Dst)
+		case OpMv:
+			cmd = exec.Command("dx", "mv", projId+":"+entry.Src, projId+":"+entry.Dst)
+		case OpChmod:
+			applied = entry.Seq
+			continue
+		default:
+			return applied, fmt.Errorf("unrecognized journal op %q", entry.Op)
+		}
+
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return applied, fmt.Errorf("replaying journal entry %d (%s %s): %s\n%s",
+				entry.Seq, entry.Op, entry.Dst, err, string(out))
+		}
+		applied = entry.Seq
+	}
+	return applied, nil
+}
+
+// FlushStagingDir replays, against the DNAnexus project projId, every
+// entry journaled so far under stagingDir that hasn't already been
+// applied -- picking up from the sequence number recorded in
+// stagingDir's flush-state file by the last successful call -- and
+// persists the new high-water mark once done. This is what a mount's
+// unmount path calls to actually push write-back changes to DNAnexus;
+// FlushJournal itself only knows how to replay a slice of entries it's
+// handed.
+func FlushStagingDir(projId, stagingDir string) (int64, error) {
+	entries, err := ReadJournal(filepath.Join(stagingDir, JournalFileName))
+	if err != nil {
+		return 0, err
+	}
+	resumeFrom, err := readFlushState(stagingDir)
+	if err != nil {
+		return 0, err
+	}
+
+	applied, flushErr := FlushJournal(projId, entries, resumeFrom)
+	if applied > resumeFrom {
+		if err := writeFlushState(stagingDir, applied); err != nil {
+			if flushErr == nil {
+				flushErr = err
+			}
+		}
+	}
+	return applied, flushErr
+}